@@ -0,0 +1,50 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/CSCfi/qvain-api/internal/connector"
+)
+
+type contextKey int
+
+const identityContextKey contextKey = iota
+
+// WithIdentity returns a copy of ctx carrying identity, the normalized result of a successful
+// login. Whatever validates a session on incoming requests should store the Identity this way
+// so Middleware and RequireGroup can find it later.
+func WithIdentity(ctx context.Context, identity *connector.Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey, identity)
+}
+
+// IdentityFromContext returns the Identity previously stored with WithIdentity, if any.
+func IdentityFromContext(ctx context.Context) (*connector.Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey).(*connector.Identity)
+	return identity, ok
+}
+
+// Middleware enforces policy on every request it wraps: 401 if the request carries no
+// Identity at all, 403 if the Identity doesn't satisfy policy.
+func Middleware(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !policy.Allowed(identity) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireGroup wraps a handler so it only runs for identities that are members of group. It is
+// the one-rule shorthand for Middleware(Policy{Rules: []Rule{{Effect: Allow, Group: group}}}).
+func RequireGroup(group string) func(http.Handler) http.Handler {
+	return Middleware(Policy{Rules: []Rule{{Effect: Allow, Group: group}}})
+}
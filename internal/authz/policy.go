@@ -0,0 +1,75 @@
+// Package authz implements a small, declarative policy engine for deciding whether an
+// authenticated request is allowed to proceed, based on the connector.Identity it carries.
+//
+// It is meant to eventually replace ad-hoc checks like oidc.ErrMissingCSCUserName and
+// oidc.ErrMissingOrganization with rules an operator can configure rather than code that has
+// to be changed and redeployed.
+package authz
+
+import (
+	"regexp"
+
+	"github.com/CSCfi/qvain-api/internal/connector"
+)
+
+// Effect is the outcome of a matching Rule.
+type Effect int
+
+const (
+	Deny Effect = iota
+	Allow
+)
+
+// Rule grants or denies access to identities matching all of its non-empty conditions. A Rule
+// with no conditions set matches every identity.
+type Rule struct {
+	Effect Effect
+
+	// Group, if set, requires the identity to be a member of this group.
+	Group string
+
+	// Organization, if set, requires the identity's Org to match exactly.
+	Organization string
+
+	// Subject, if set, is matched as a regular expression against the identity's Subject.
+	Subject *regexp.Regexp
+}
+
+// matches reports whether identity satisfies every condition set on r.
+func (r Rule) matches(identity *connector.Identity) bool {
+	if r.Group != "" && !contains(identity.Groups, r.Group) {
+		return false
+	}
+	if r.Organization != "" && identity.Org != r.Organization {
+		return false
+	}
+	if r.Subject != nil && !r.Subject.MatchString(identity.Subject) {
+		return false
+	}
+	return true
+}
+
+// Policy is an ordered list of Rules, evaluated first to last. The first matching rule's
+// Effect decides the outcome; an identity that matches no rule is denied.
+type Policy struct {
+	Rules []Rule
+}
+
+// Allowed reports whether identity is allowed by p.
+func (p Policy) Allowed(identity *connector.Identity) bool {
+	for _, rule := range p.Rules {
+		if rule.matches(identity) {
+			return rule.Effect == Allow
+		}
+	}
+	return false
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package authz
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/CSCfi/qvain-api/internal/connector"
+)
+
+func TestPolicyAllowedOrdering(t *testing.T) {
+	policy := Policy{Rules: []Rule{
+		{Effect: Deny, Subject: regexp.MustCompile(`^banned-`)},
+		{Effect: Allow, Group: "admin"},
+		{Effect: Allow, Organization: "csc.fi"},
+	}}
+
+	cases := []struct {
+		name     string
+		identity *connector.Identity
+		want     bool
+	}{
+		{"first matching rule wins even if a later rule would allow", &connector.Identity{Subject: "banned-mallory", Groups: []string{"admin"}}, false},
+		{"group match allows", &connector.Identity{Subject: "alice", Groups: []string{"admin"}}, true},
+		{"organization match allows", &connector.Identity{Subject: "bob", Org: "csc.fi"}, true},
+		{"no rule matches, default deny", &connector.Identity{Subject: "eve", Org: "example.com"}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := policy.Allowed(c.identity); got != c.want {
+				t.Errorf("Allowed(%+v) = %v, want %v", c.identity, got, c.want)
+			}
+		})
+	}
+}
+
+func TestEmptyPolicyDeniesEverything(t *testing.T) {
+	var policy Policy
+	if policy.Allowed(&connector.Identity{Subject: "anyone"}) {
+		t.Error("an empty policy should deny everything")
+	}
+}
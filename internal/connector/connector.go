@@ -0,0 +1,99 @@
+// Package connector normalizes logins from several identity providers behind one HTTP surface.
+//
+// A Connector authenticates against a single IdP and returns a normalized Identity; a Registry
+// mounts any number of them at "{prefix}/{id}/login" and "{prefix}/{id}/callback", handling the
+// state cookie dance generically so individual connectors only need to deal with their
+// provider's own token exchange.
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Identity is the normalized result of a successful login, regardless of which Connector
+// produced it. Application code should depend on this type, not on provider-specific claims.
+type Identity struct {
+	Subject     string
+	Email       string
+	Name        string
+	Groups      []string
+	Org         string
+	CSCUserName string
+}
+
+// Connector authenticates users against a single identity provider and normalizes the result
+// to an Identity.
+type Connector interface {
+	// ID is the identifier this connector is registered and mounted under, e.g. "github".
+	ID() string
+
+	// LoginURL returns the URL to send the user to in order to start a login. state must be
+	// echoed back unmodified by the provider; nonce is only meaningful for OIDC-based connectors.
+	LoginURL(state, nonce string) string
+
+	// HandleCallback validates the callback request and returns the authenticated Identity.
+	HandleCallback(r *http.Request) (*Identity, error)
+
+	// Refresh renews the session identified by sessionID and returns its current Identity. It
+	// returns ErrRefreshNotSupported for connectors with no notion of a refreshable session,
+	// e.g. LDAPConnector's direct-bind flow.
+	Refresh(ctx context.Context, sessionID string) (*Identity, error)
+}
+
+// ErrConnectorExists is returned by Registry.Register if a connector is already registered
+// under the given ID.
+var ErrConnectorExists = errors.New("connector already registered")
+
+// ErrRefreshNotSupported is returned by Connector.Refresh implementations that have no
+// refreshable session concept.
+var ErrRefreshNotSupported = errors.New("connector: refresh not supported")
+
+// OnLogin is called after a connector successfully authenticates a user, mirroring the role
+// OidcClient.OnLogin played for the single-provider client.
+type OnLogin func(w http.ResponseWriter, r *http.Request, identity *Identity) error
+
+// Registry mounts a set of Connectors behind a common login/callback HTTP surface.
+type Registry struct {
+	connectors map[string]Connector
+
+	// FrontendURL is where the user is redirected to after a successful login.
+	FrontendURL string
+
+	// OnLogin is called with the normalized Identity once a connector's callback succeeds.
+	OnLogin OnLogin
+}
+
+// NewRegistry creates an empty Registry. Connectors are added with Register.
+func NewRegistry(frontendURL string, onLogin OnLogin) *Registry {
+	return &Registry{
+		connectors:  make(map[string]Connector),
+		FrontendURL: frontendURL,
+		OnLogin:     onLogin,
+	}
+}
+
+// Register adds connector to the registry under its ID.
+func (reg *Registry) Register(connector Connector) error {
+	if _, exists := reg.connectors[connector.ID()]; exists {
+		return ErrConnectorExists
+	}
+	reg.connectors[connector.ID()] = connector
+	return nil
+}
+
+// Get returns the connector registered under id.
+func (reg *Registry) Get(id string) (Connector, bool) {
+	c, ok := reg.connectors[id]
+	return c, ok
+}
+
+// Mount registers a "{prefix}/{id}/login" and "{prefix}/{id}/callback" handler pair for every
+// registered connector on mux, e.g. Mount(mux, "/api/auth") yields "/api/auth/github/login".
+func (reg *Registry) Mount(mux *http.ServeMux, prefix string) {
+	for id, connector := range reg.connectors {
+		mux.HandleFunc(prefix+"/"+id+"/login", reg.loginHandler(connector))
+		mux.HandleFunc(prefix+"/"+id+"/callback", reg.callbackHandler(connector))
+	}
+}
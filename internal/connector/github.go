@@ -0,0 +1,96 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// ErrNoVerifiedEmail is returned when a GitHub account has no verified email address to log in with.
+var ErrNoVerifiedEmail = errors.New("github account has no verified email")
+
+// GitHubConnector authenticates users against GitHub using OAuth2, fetching the profile and
+// verified email from the REST API. It does not populate Identity.Groups; use an authz policy
+// keyed on Org or Subject instead.
+type GitHubConnector struct {
+	id     string
+	config oauth2.Config
+}
+
+// NewGitHubConnector creates a GitHubConnector registered under id.
+func NewGitHubConnector(id, clientID, clientSecret, redirectURL string) *GitHubConnector {
+	return &GitHubConnector{
+		id: id,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     github.Endpoint,
+			Scopes:       []string{"read:user", "user:email"},
+		},
+	}
+}
+
+func (c *GitHubConnector) ID() string {
+	return c.id
+}
+
+// LoginURL returns the URL to redirect the user to; GitHub's OAuth2 flow has no nonce parameter.
+func (c *GitHubConnector) LoginURL(state, _ string) string {
+	return c.config.AuthCodeURL(state)
+}
+
+func (c *GitHubConnector) HandleCallback(r *http.Request) (*Identity, error) {
+	ctx := r.Context()
+
+	token, err := c.config.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		return nil, err
+	}
+	client := c.config.Client(ctx, token)
+
+	var user struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(client, "https://api.github.com/user", &user); err != nil {
+		return nil, err
+	}
+
+	email := user.Email
+	if email == "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := getJSON(client, "https://api.github.com/user/emails", &emails); err != nil {
+			return nil, err
+		}
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				email = e.Email
+				break
+			}
+		}
+	}
+	if email == "" {
+		return nil, ErrNoVerifiedEmail
+	}
+
+	return &Identity{
+		Subject: strconv.Itoa(user.ID),
+		Email:   email,
+		Name:    user.Name,
+	}, nil
+}
+
+// Refresh always fails: this connector doesn't track sessions, so there's nothing to renew.
+func (c *GitHubConnector) Refresh(ctx context.Context, sessionID string) (*Identity, error) {
+	return nil, ErrRefreshNotSupported
+}
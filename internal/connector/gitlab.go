@@ -0,0 +1,78 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+)
+
+// GitLabConnector authenticates users against a GitLab instance (gitlab.com or a self-hosted
+// install) using OAuth2, fetching the profile from the REST API.
+type GitLabConnector struct {
+	id      string
+	config  oauth2.Config
+	baseURL string
+}
+
+// NewGitLabConnector creates a GitLabConnector registered under id. baseURL defaults to
+// "https://gitlab.com" if empty, which also covers self-hosted GitLab instances.
+func NewGitLabConnector(id, clientID, clientSecret, redirectURL, baseURL string) *GitLabConnector {
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+
+	return &GitLabConnector{
+		id:      id,
+		baseURL: baseURL,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/oauth/authorize",
+				TokenURL: baseURL + "/oauth/token",
+			},
+			Scopes: []string{"read_user"},
+		},
+	}
+}
+
+func (c *GitLabConnector) ID() string {
+	return c.id
+}
+
+func (c *GitLabConnector) LoginURL(state, _ string) string {
+	return c.config.AuthCodeURL(state)
+}
+
+func (c *GitLabConnector) HandleCallback(r *http.Request) (*Identity, error) {
+	ctx := r.Context()
+
+	token, err := c.config.Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		return nil, err
+	}
+	client := c.config.Client(ctx, token)
+
+	var user struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := getJSON(client, c.baseURL+"/api/v4/user", &user); err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Subject: strconv.Itoa(user.ID),
+		Email:   user.Email,
+		Name:    user.Name,
+	}, nil
+}
+
+// Refresh always fails: this connector doesn't track sessions, so there's nothing to renew.
+func (c *GitLabConnector) Refresh(ctx context.Context, sessionID string) (*Identity, error) {
+	return nil, ErrRefreshNotSupported
+}
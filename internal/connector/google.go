@@ -0,0 +1,76 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/CSCfi/qvain-api/internal/oidc"
+)
+
+// GoogleConnector authenticates users against Google, which is a standard OIDC provider, and
+// optionally enriches the Identity with group memberships from the Admin SDK Directory API.
+// Directory lookups require a domain-wide delegated client and are skipped if GroupsClient is nil.
+type GoogleConnector struct {
+	*OIDCConnector
+
+	// GroupsClient, if set, is used to query the Directory API's groups.list endpoint for the
+	// logged in user's email. It must already be authenticated with domain-wide delegation for
+	// the "https://www.googleapis.com/auth/admin.directory.group.readonly" scope.
+	GroupsClient *http.Client
+}
+
+// NewGoogleConnector wraps client, a generic oidc.OidcClient configured for
+// "https://accounts.google.com", as a Connector registered under id.
+func NewGoogleConnector(id string, client *oidc.OidcClient) *GoogleConnector {
+	return &GoogleConnector{OIDCConnector: NewOIDCConnector(id, client)}
+}
+
+func (c *GoogleConnector) HandleCallback(r *http.Request) (*Identity, error) {
+	identity, err := c.OIDCConnector.HandleCallback(r)
+	if err != nil {
+		return nil, err
+	}
+	return c.enrichGroups(identity)
+}
+
+// Refresh overrides OIDCConnector.Refresh to reapply the Directory API group enrichment;
+// otherwise a refreshed session would silently lose the groups HandleCallback populated, since
+// Google's ID token carries no groups claim for identityFromIDToken to fall back on.
+func (c *GoogleConnector) Refresh(ctx context.Context, sessionID string) (*Identity, error) {
+	identity, err := c.OIDCConnector.Refresh(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return c.enrichGroups(identity)
+}
+
+func (c *GoogleConnector) enrichGroups(identity *Identity) (*Identity, error) {
+	if c.GroupsClient != nil && identity.Email != "" {
+		groups, err := c.lookupGroups(identity.Email)
+		if err != nil {
+			return nil, err
+		}
+		identity.Groups = groups
+	}
+	return identity, nil
+}
+
+func (c *GoogleConnector) lookupGroups(email string) ([]string, error) {
+	var result struct {
+		Groups []struct {
+			Email string `json:"email"`
+		} `json:"groups"`
+	}
+
+	endpoint := "https://admin.googleapis.com/admin/directory/v1/groups?userKey=" + url.QueryEscape(email)
+	if err := getJSON(c.GroupsClient, endpoint, &result); err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(result.Groups))
+	for _, g := range result.Groups {
+		groups = append(groups, g.Email)
+	}
+	return groups, nil
+}
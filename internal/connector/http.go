@@ -0,0 +1,81 @@
+package connector
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CSCfi/qvain-api/internal/randomkey"
+)
+
+const (
+	// stateCookieName is the cookie used to protect the login against CSRF/replay while the
+	// user is away at the IdP.
+	stateCookieName = "qvain_connector_state"
+	stateCookiePath = "/"
+
+	// loginTimeout is the age, in seconds, of the state cookie during login.
+	loginTimeout = 600 // 10m
+)
+
+// loginHandler redirects to connector's provider, first setting a state cookie that callback
+// handler below uses to guard against CSRF.
+func (reg *Registry) loginHandler(connector Connector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := randomkey.Random16()
+		if err != nil {
+			http.Error(w, "can't create state parameter", http.StatusInternalServerError)
+			return
+		}
+
+		nonce, err := randomkey.Random16()
+		if err != nil {
+			http.Error(w, "can't create nonce", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookieName,
+			Value:    state.Base64(),
+			Path:     stateCookiePath,
+			Expires:  time.Now().Add(loginTimeout * time.Second),
+			MaxAge:   loginTimeout,
+			Secure:   true,
+			HttpOnly: true,
+		})
+
+		http.Redirect(w, r, connector.LoginURL(state.Base64(), nonce.Base64()), http.StatusFound)
+	}
+}
+
+// callbackHandler validates the state cookie, lets connector turn the callback request into an
+// Identity, and hands it to the registry's OnLogin callback.
+func (reg *Registry) callbackHandler(connector Connector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(stateCookieName)
+		if err != nil {
+			http.Error(w, "login session expired", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: stateCookieName, Value: "", Path: stateCookiePath, MaxAge: -1})
+
+		if r.URL.Query().Get("state") != cookie.Value {
+			http.Error(w, "state did not match", http.StatusBadRequest)
+			return
+		}
+
+		identity, err := connector.HandleCallback(r)
+		if err != nil {
+			http.Error(w, "login failed", http.StatusInternalServerError)
+			return
+		}
+
+		if reg.OnLogin != nil {
+			if err := reg.OnLogin(w, r, identity); err != nil {
+				http.Error(w, "login failed", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		http.Redirect(w, r, reg.FrontendURL, http.StatusFound)
+	}
+}
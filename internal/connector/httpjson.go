@@ -0,0 +1,22 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// getJSON performs a GET request with client and decodes a JSON response body into v.
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,38 @@
+package connector
+
+import "strings"
+
+// lookupPointer resolves a JSON-pointer-style path (e.g. "/realm_access/roles") against decoded
+// JSON claims. A string value at that path yields a single element; an array yields one element
+// per string item in it. A missing path, or one that isn't a string or array of strings, yields
+// nothing.
+func lookupPointer(claims map[string]interface{}, pointer string) []string {
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+
+	var cur interface{} = claims
+	for _, part := range parts {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur, ok = m[part]
+		if !ok {
+			return nil
+		}
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
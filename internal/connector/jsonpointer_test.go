@@ -0,0 +1,40 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLookupPointer(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups": []interface{}{"admin", "users"},
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"offline_access", "uma_authorization"},
+		},
+		"HomeOrganization": "csc.fi",
+		"mixed":            []interface{}{"a", 1, "b"},
+	}
+
+	cases := []struct {
+		name    string
+		pointer string
+		want    []string
+	}{
+		{"top level array", "/groups", []string{"admin", "users"}},
+		{"nested array", "/realm_access/roles", []string{"offline_access", "uma_authorization"}},
+		{"single string", "/HomeOrganization", []string{"csc.fi"}},
+		{"non-string elements are dropped", "/mixed", []string{"a", "b"}},
+		{"missing path", "/nope", nil},
+		{"missing nested path", "/realm_access/nope", nil},
+		{"path through a non-object", "/HomeOrganization/nope", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := lookupPointer(claims, c.pointer)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("lookupPointer(%q) = %v, want %v", c.pointer, got, c.want)
+			}
+		})
+	}
+}
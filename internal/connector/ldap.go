@@ -0,0 +1,106 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrLDAPCredentials is returned when the LDAP bind fails, i.e. the username or password is wrong.
+var ErrLDAPCredentials = errors.New("invalid username or password")
+
+// LDAPConnector authenticates users by performing a direct bind against a fixed LDAP server.
+// Unlike the other connectors, it does not use the OAuth2 authorization code flow: LoginURL
+// points at a local login form, and HandleCallback expects "username" and "password" form
+// values instead of an authorization "code".
+type LDAPConnector struct {
+	id string
+
+	addr string
+	// bindDN is a fmt template for the user's DN with a single %s placeholder for the
+	// (DN-escaped) username, e.g. "uid=%s,ou=people,dc=example,dc=org".
+	bindDN   string
+	loginURL string
+}
+
+// NewLDAPConnector creates an LDAPConnector registered under id, binding against addr with the
+// user's DN built from bindDN. loginURL is the page the user is sent to in order to enter
+// their credentials; it must POST "username" and "password" back to this connector's callback.
+func NewLDAPConnector(id, addr, bindDN, loginURL string) *LDAPConnector {
+	return &LDAPConnector{id: id, addr: addr, bindDN: bindDN, loginURL: loginURL}
+}
+
+func (c *LDAPConnector) ID() string {
+	return c.id
+}
+
+func (c *LDAPConnector) LoginURL(state, _ string) string {
+	return c.loginURL + "?state=" + state
+}
+
+func (c *LDAPConnector) HandleCallback(r *http.Request) (*Identity, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	username := r.PostFormValue("username")
+	password := r.PostFormValue("password")
+	if username == "" || password == "" {
+		return nil, ErrLDAPCredentials
+	}
+
+	conn, err := ldap.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("ldap dial: %w", err)
+	}
+	defer conn.Close()
+
+	dn := fmt.Sprintf(c.bindDN, escapeDN(username))
+	if err := conn.Bind(dn, password); err != nil {
+		return nil, ErrLDAPCredentials
+	}
+
+	return &Identity{
+		Subject: dn,
+		Name:    username,
+	}, nil
+}
+
+// Refresh always fails: a direct LDAP bind has no session to renew, so there's nothing to
+// refresh the way an OAuth2 connector refreshes an access token.
+func (c *LDAPConnector) Refresh(ctx context.Context, sessionID string) (*Identity, error) {
+	return nil, ErrRefreshNotSupported
+}
+
+// escapeDN escapes s for safe inclusion as one attribute value within a DN, per RFC 4514
+// section 2.4. ldap.EscapeFilter is for search filters and does not neutralize DN metacharacters
+// (",", "+", "=", "<", ">", ";"), so using it here would let a crafted username splice extra
+// RDNs into bindDN.
+func escapeDN(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+
+	for i, r := range runes {
+		switch {
+		case r == ',' || r == '+' || r == '"' || r == '\\' || r == '<' || r == '>' || r == ';' || r == '=':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		case (r == '#' || r == ' ') && i == 0:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == ' ' && i == len(runes)-1:
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -2,12 +2,18 @@
 package oidc
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/CSCfi/qvain-api/internal/randomkey"
+	"github.com/CSCfi/qvain-api/internal/sessions"
 
 	gooidc "github.com/coreos/go-oidc"
 	"github.com/rs/zerolog"
@@ -21,6 +27,9 @@ const (
 
 	// DefaultCookiePath sets the URL path cookies from this package are valid for.
 	DefaultCookiePath = "/api/auth"
+
+	// StateCookieName is the cookie the login state is stored under between Auth and Callback.
+	StateCookieName = "qvain_state"
 )
 
 var ErrMissingCSCUserName = errors.New("Missing CSCUserName field")
@@ -28,6 +37,10 @@ var ErrMissingCSCUserName = errors.New("Missing CSCUserName field")
 // User should have home organization
 var ErrMissingOrganization = errors.New("Missing Organization field")
 
+// ErrNoRevocationEndpoint is returned if the provider's metadata document does not advertise
+// a revocation endpoint, so Logout cannot revoke the refresh token.
+var ErrNoRevocationEndpoint = errors.New("provider has no revocation endpoint")
+
 // OidcClient holds the OpenID Connect and OAuth2 configuration for an authentication provider.
 type OidcClient struct {
 	Name        string
@@ -43,6 +56,15 @@ type OidcClient struct {
 	oauthConfig  oauth2.Config
 	oidcConfig   *gooidc.Config
 
+	// refresher keeps logged in sessions alive across access token expiry; nil disables
+	// session persistence and refresh entirely, which is the previous behaviour.
+	refresher *TokenRefresher
+
+	// sessionStore holds the login state between Auth and Callback. It defaults to an
+	// in-process CookieStore, so the client works without extra configuration, but can be
+	// replaced with WithSessionStore to share state across instances, e.g. via Redis.
+	sessionStore sessions.Store
+
 	//OnLogin func(w http.ResponseWriter, r *http.Request, sub string, exp time.Time) error
 	//OnLogin func(http.ResponseWriter, *http.Request, *oauth2.Token, *gooidc.IDToken) error
 	OnLogin func(http.ResponseWriter, *http.Request, *oauth2.Token, *gooidc.IDToken) error
@@ -66,6 +88,24 @@ func WithSkipExpiryCheck(val bool) func(*OidcClient) {
 // OidcClientOption is used for passing optional configuration to a OidcClient.
 type OidcClientOption func(*OidcClient)
 
+// WithSessionStore sets the sessions.Store used to hold login state between Auth and Callback,
+// replacing the default in-process CookieStore. Use this to share state across instances, e.g.
+// behind a load balancer, by passing a sessions.RedisStore.
+func WithSessionStore(store sessions.Store) OidcClientOption {
+	return func(client *OidcClient) {
+		client.sessionStore = store
+	}
+}
+
+// authState is the payload kept in the state cookie across a login. Verifier and Nonce are
+// both required for a real (non dev-token) login: Verifier is the PKCE code verifier (RFC
+// 7636) and Nonce is bound to the ID token to prevent authorization code injection and replay.
+type authState struct {
+	State    string `json:"state"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
 // NewOidcClient creates a new OpenID Connect client for the given provider and credentials.
 func NewOidcClient(name string, id string, secret string, redirectUrl string,
 	providerUrl string, frontendUrl string, options ...OidcClientOption) (*OidcClient, error) {
@@ -106,6 +146,22 @@ func NewOidcClient(name string, id string, secret string, redirectUrl string,
 		option(&client)
 	}
 
+	if client.sessionStore == nil {
+		secret, err := randomkey.Random32()
+		if err != nil {
+			return nil, err
+		}
+		client.sessionStore, err = sessions.NewCookieStore(secret.Bytes(), DefaultLoginTimeout*time.Second, sessions.CookieOptions{
+			Path:     DefaultCookiePath,
+			Secure:   true,
+			HttpOnly: true,
+			MaxAge:   DefaultLoginTimeout * time.Second,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &client, nil
 }
 
@@ -115,11 +171,76 @@ func (client *OidcClient) SetLogger(logger zerolog.Logger) {
 	client.logger = logger
 }
 
+// SetTokenRefresher attaches a TokenRefresher to the client, enabling session persistence:
+// successful logins store their refresh token in the refresher's TokenStore under a session
+// cookie, Refresh can later be used to renew the access token, and Logout will revoke it.
+// It is probably not safe to call this after the handlers are instantiated.
+func (client *OidcClient) SetTokenRefresher(refresher *TokenRefresher) {
+	client.refresher = refresher
+}
+
+// AuthCodeURL returns the URL to redirect the user to the provider's authorization endpoint,
+// with the given state and nonce. It lets callers that handle the HTTP plumbing themselves
+// (e.g. a Connector adapter) reuse the client's OAuth2 configuration instead of Auth().
+//
+// This does not set a PKCE code_challenge; use AuthCodeURLPKCE for that, which Exchange won't
+// verify. Callers that offer only this method's weaker flow still get the Nonce check that
+// Exchange performs during token verification.
+func (client *OidcClient) AuthCodeURL(state, nonce string) string {
+	return client.oauthConfig.AuthCodeURL(state, gooidc.Nonce(nonce))
+}
+
+// AuthCodeURLPKCE is like AuthCodeURL, but adds a PKCE (RFC 7636) S256 code_challenge derived
+// from verifier. The caller must hold onto verifier and pass it to ExchangePKCE once the
+// provider redirects back, or the token endpoint will reject the exchange.
+func (client *OidcClient) AuthCodeURLPKCE(state, nonce, verifier string) string {
+	return client.oauthConfig.AuthCodeURL(state, gooidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", codeChallengeS256(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+// Exchange exchanges an OAuth2 authorization code for a token and verifies its ID token,
+// without performing the cookie and redirect handling that Callback() does. It lets callers
+// that handle the HTTP plumbing themselves (e.g. a Connector adapter) reuse the client's
+// configuration and verifier.
+func (client *OidcClient) Exchange(ctx context.Context, code string) (*oauth2.Token, *gooidc.IDToken, error) {
+	return client.exchange(ctx, code)
+}
+
+// ExchangePKCE is like Exchange, but sends the PKCE code_verifier (RFC 7636) matching the
+// code_challenge used to build the authorization URL via AuthCodeURLPKCE.
+func (client *OidcClient) ExchangePKCE(ctx context.Context, code, verifier string) (*oauth2.Token, *gooidc.IDToken, error) {
+	return client.exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+}
+
+func (client *OidcClient) exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, *gooidc.IDToken, error) {
+	oauth2Token, err := client.oauthConfig.Exchange(ctx, code, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
+	if !ok {
+		return nil, nil, errors.New("IdP did not send an id token")
+	}
+
+	idToken, err := client.oidcVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return oauth2Token, idToken, nil
+}
+
+// codeChallengeS256 derives a PKCE (RFC 7636) S256 code challenge from verifier.
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 // Auth is a HTTP handler that forwards the OIDC client to the Authorization endpoint.
 func (client *OidcClient) Auth() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		nonce := r.URL.RawQuery
-
 		key, err := randomkey.Random16()
 		if err != nil {
 			client.logger.Error().Err(err).Msg("can't create state parameter")
@@ -128,16 +249,35 @@ func (client *OidcClient) Auth() http.HandlerFunc {
 		}
 		state := key.Base64()
 
-		http.SetCookie(w, &http.Cookie{
-			Name:  "state",
-			Value: state,
-			Path:  DefaultCookiePath,
-			// old browsers such as IE<=8 don't understand MaxAge; use Expires or leave it unset to make this a "session cookie"
-			Expires:  time.Now().Add(DefaultLoginTimeout * time.Second),
-			MaxAge:   DefaultLoginTimeout,
-			Secure:   true,
-			HttpOnly: true,
-		})
+		// PKCE code verifier: Random32 yields a 43 character base64url string, the shortest
+		// length RFC 7636 allows.
+		verifierKey, err := randomkey.Random32()
+		if err != nil {
+			client.logger.Error().Err(err).Msg("can't create code verifier")
+			http.Error(w, "can't create state parameter", http.StatusInternalServerError)
+			return
+		}
+		verifier := verifierKey.Base64()
+
+		nonceKey, err := randomkey.Random16()
+		if err != nil {
+			client.logger.Error().Err(err).Msg("can't create nonce")
+			http.Error(w, "can't create state parameter", http.StatusInternalServerError)
+			return
+		}
+		nonce := nonceKey.Base64()
+
+		payload, err := json.Marshal(authState{State: state, Verifier: verifier, Nonce: nonce})
+		if err != nil {
+			client.logger.Error().Err(err).Msg("can't marshal state payload")
+			http.Error(w, "can't create state parameter", http.StatusInternalServerError)
+			return
+		}
+		if err := client.sessionStore.Save(w, StateCookieName, payload); err != nil {
+			client.logger.Error().Err(err).Msg("can't save state cookie")
+			http.Error(w, "can't create state parameter", http.StatusInternalServerError)
+			return
+		}
 
 		// allow login with custom ID token if in developer mode
 		if rawIDToken := r.URL.Query().Get("token"); rawIDToken != "" {
@@ -147,14 +287,19 @@ func (client *OidcClient) Auth() http.HandlerFunc {
 				return
 			}
 
-			// redirect to our callback url instead of the IdP
-			client.logger.Debug().Str("state", state).Bool("withNonce", len(nonce) > 0).Msg("logging in with dev token, redirect to callback")
+			// redirect to our callback url instead of the IdP; PKCE and nonce don't apply, as
+			// there is no authorization code exchange or provider-issued ID token to bind them to
+			client.logger.Debug().Str("state", state).Msg("logging in with dev token, redirect to callback")
 			http.Redirect(w, r, client.oauthConfig.RedirectURL+"?token="+rawIDToken+"&state="+state, http.StatusFound)
 			return
 		}
 
-		client.logger.Debug().Str("state", state).Bool("withNonce", len(nonce) > 0).Msg("redirect to IdP")
-		http.Redirect(w, r, client.oauthConfig.AuthCodeURL(state, gooidc.Nonce(nonce)), http.StatusFound)
+		authURL := client.oauthConfig.AuthCodeURL(state, gooidc.Nonce(nonce),
+			oauth2.SetAuthURLParam("code_challenge", codeChallengeS256(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+		client.logger.Debug().Str("state", state).Msg("redirect to IdP")
+		http.Redirect(w, r, authURL, http.StatusFound)
 	}
 }
 
@@ -168,19 +313,37 @@ func (client *OidcClient) Callback() http.HandlerFunc {
 			ok          bool
 		)
 
-		cookie, err := r.Cookie("state")
+		payload, err := client.sessionStore.Load(r, StateCookieName)
 		if err != nil {
-			client.logger.Debug().Msg("no state cookie")
+			client.logger.Debug().Err(err).Msg("no state cookie")
 			http.Error(w, "login session expired", http.StatusBadRequest)
 			return
 		}
+		client.sessionStore.Clear(w, StateCookieName)
 
-		if r.URL.Query().Get("state") != cookie.Value {
-			client.logger.Debug().Str("param", r.URL.Query().Get("state")).Str("cookie", cookie.Value).Msg("state did not match")
+		var state authState
+		if err := json.Unmarshal(payload, &state); err != nil {
+			client.logger.Error().Err(err).Msg("can't unmarshal state payload")
+			http.Error(w, "login session expired", http.StatusBadRequest)
+			return
+		}
+
+		if r.URL.Query().Get("state") != state.State {
+			client.logger.Debug().Str("param", r.URL.Query().Get("state")).Msg("state did not match")
 			http.Error(w, "state did not match", http.StatusBadRequest)
 			return
 		}
 
+		isDevLogin := r.URL.Query().Get("token") != ""
+
+		// a real login always goes through Auth(), which always sets both fields; their
+		// absence means the state cookie was forged or, for legacy cookies, predates PKCE/nonce
+		if !isDevLogin && (state.Verifier == "" || state.Nonce == "") {
+			client.logger.Debug().Msg("state cookie missing verifier or nonce")
+			http.Error(w, "login session expired", http.StatusBadRequest)
+			return
+		}
+
 		if rawIDToken = r.URL.Query().Get("token"); rawIDToken != "" {
 			// login with custom ID token, oauth2Token will be nil
 			if !client.allowDevLogin {
@@ -189,8 +352,10 @@ func (client *OidcClient) Callback() http.HandlerFunc {
 				return
 			}
 		} else {
-			// get OAuth2 token using authorization code, extract ID token
-			oauth2Token, err = client.oauthConfig.Exchange(ctx, r.URL.Query().Get("code"))
+			// get OAuth2 token using authorization code, extract ID token; the code verifier
+			// proves this client is the one that started the flow (RFC 7636)
+			oauth2Token, err = client.oauthConfig.Exchange(ctx, r.URL.Query().Get("code"),
+				oauth2.SetAuthURLParam("code_verifier", state.Verifier))
 			if err != nil {
 				client.logger.Error().Err(err).Msg("token exchange failed")
 				http.Error(w, "failed to exchange code for token", http.StatusInternalServerError)
@@ -211,9 +376,23 @@ func (client *OidcClient) Callback() http.HandlerFunc {
 			return
 		}
 
+		if !isDevLogin && idToken.Nonce != state.Nonce {
+			client.logger.Error().Msg("id token nonce does not match")
+			http.Error(w, "id token verification failed", http.StatusInternalServerError)
+			return
+		}
+
 		// client is now successfully logged in
 		client.logger.Info().Str("sub", idToken.Subject).Msg("login")
 
+		// persist the token so it can be refreshed later on, instead of forcing the user
+		// to log in again once the access token expires
+		if client.refresher != nil && oauth2Token != nil {
+			if err := client.storeSession(w, oauth2Token, rawIDToken); err != nil {
+				client.logger.Error().Err(err).Msg("failed to store session for refresh")
+			}
+		}
+
 		// OnLogin callback; don't write to the response before this as it might try to set a cookie
 		//if client.OnLogin != nil && client.OnLogin(w, r, idToken.Subject, oauth2Token.Expiry) != nil {
 		if client.OnLogin != nil {
@@ -238,6 +417,88 @@ func (client *OidcClient) Callback() http.HandlerFunc {
 	}
 }
 
+// storeSession saves the freshly obtained token pair under a new session id and sets the
+// corresponding session cookie, so it can later be found again by TokenRefresher.
+func (client *OidcClient) storeSession(w http.ResponseWriter, oauth2Token *oauth2.Token, rawIDToken string) error {
+	key, err := randomkey.Random16()
+	if err != nil {
+		return err
+	}
+	sid := key.Base64()
+
+	if err := client.refresher.Store().Put(sid, &StoredToken{OAuth2Token: oauth2Token, RawIDToken: rawIDToken}); err != nil {
+		return err
+	}
+
+	setSessionCookie(w, sid)
+	return nil
+}
+
+// Logout is a HTTP handler that revokes the refresh token for the current session at the
+// provider's RFC 7009 revocation endpoint and clears the session and state cookies. It is a
+// no-op, save for clearing cookies, if the client has no TokenRefresher configured.
+func (client *OidcClient) Logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer clearSessionCookie(w)
+
+		if client.refresher == nil {
+			return
+		}
+
+		cookie, err := r.Cookie(SessionCookieName)
+		if err != nil {
+			return
+		}
+
+		stored, err := client.refresher.Store().Get(cookie.Value)
+		if err == nil && stored.OAuth2Token.RefreshToken != "" {
+			if err := client.revokeToken(r.Context(), stored.OAuth2Token.RefreshToken); err != nil {
+				client.logger.Warn().Err(err).Msg("refresh token revocation failed")
+			}
+		}
+
+		if err := client.refresher.Store().Delete(cookie.Value); err != nil {
+			client.logger.Warn().Err(err).Str("sid", cookie.Value).Msg("failed to delete session")
+		}
+	}
+}
+
+// revokeToken revokes token at the provider's revocation endpoint (RFC 7009), discovered from
+// the provider's metadata document.
+func (client *OidcClient) revokeToken(ctx context.Context, token string) error {
+	var meta struct {
+		RevocationEndpoint string `json:"revocation_endpoint"`
+	}
+	if err := client.oidcProvider.Claims(&meta); err != nil {
+		return err
+	}
+	if meta.RevocationEndpoint == "" {
+		return ErrNoRevocationEndpoint
+	}
+
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"refresh_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.RevocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.clientID, client.oauthConfig.ClientSecret)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("revocation endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
 func (client *OidcClient) DumpToken(w http.ResponseWriter, token *oauth2.Token, idToken *gooidc.IDToken) {
 	// censor access token
 	if token.AccessToken != "" {
@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// SessionCookieName is the name of the cookie used to look up a stored, refreshable session.
+	SessionCookieName = "qvain_sid"
+
+	// RefreshMargin is how long before expiry the background refresher proactively renews a token.
+	RefreshMargin = 60 * time.Second
+
+	// DefaultPollInterval is how often the background refresher checks for tokens nearing expiry.
+	DefaultPollInterval = 30 * time.Second
+)
+
+// ErrSessionNotFound is returned by a TokenStore when no token is stored for a given session id.
+var ErrSessionNotFound = errors.New("session not found")
+
+// StoredToken is the token material kept for a logged in session so it can be refreshed later.
+type StoredToken struct {
+	OAuth2Token *oauth2.Token
+	RawIDToken  string
+}
+
+// TokenStore persists StoredToken values by session id. Implementations must be safe for
+// concurrent use, as the background refresher and HTTP handlers access it from different goroutines.
+type TokenStore interface {
+	Get(sessionID string) (*StoredToken, error)
+	Put(sessionID string, token *StoredToken) error
+	Delete(sessionID string) error
+
+	// Sessions returns the ids of all sessions currently known to the store, so the
+	// background refresher can find tokens that need renewing.
+	Sessions() []string
+}
+
+// memoryTokenStore is the default TokenStore, backed by an in-memory map. It does not survive
+// a process restart.
+type memoryTokenStore struct {
+	mu   sync.RWMutex
+	data map[string]*StoredToken
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{data: make(map[string]*StoredToken)}
+}
+
+func (s *memoryTokenStore) Get(sessionID string) (*StoredToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.data[sessionID]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return token, nil
+}
+
+func (s *memoryTokenStore) Put(sessionID string, token *StoredToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[sessionID] = token
+	return nil
+}
+
+func (s *memoryTokenStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, sessionID)
+	return nil
+}
+
+func (s *memoryTokenStore) Sessions() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.data))
+	for id := range s.data {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// TokenRefresher renews OAuth2 tokens using their refresh token before the access token expires,
+// either on demand via Refresh or proactively via Start.
+type TokenRefresher struct {
+	client *OidcClient
+	store  TokenStore
+
+	margin       time.Duration
+	pollInterval time.Duration
+}
+
+// NewTokenRefresher creates a TokenRefresher for client, persisting tokens in store. If store
+// is nil, an in-memory store is used, which does not survive a process restart.
+func NewTokenRefresher(client *OidcClient, store TokenStore) *TokenRefresher {
+	if store == nil {
+		store = newMemoryTokenStore()
+	}
+
+	return &TokenRefresher{
+		client:       client,
+		store:        store,
+		margin:       RefreshMargin,
+		pollInterval: DefaultPollInterval,
+	}
+}
+
+// Store returns the TokenStore backing this refresher.
+func (tr *TokenRefresher) Store() TokenStore {
+	return tr.store
+}
+
+// Refresh exchanges the refresh token stored for sessionID for a new OAuth2 token via the
+// provider's token endpoint, re-verifies the returned ID token and persists the result.
+func (tr *TokenRefresher) Refresh(ctx context.Context, sessionID string) (*oauth2.Token, *gooidc.IDToken, error) {
+	stored, err := tr.store.Get(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newToken, err := tr.client.oauthConfig.TokenSource(ctx, stored.OAuth2Token).Token()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawIDToken, ok := newToken.Extra("id_token").(string)
+	if !ok {
+		// the provider didn't rotate the ID token; keep the one we already verified
+		rawIDToken = stored.RawIDToken
+	}
+
+	idToken, err := tr.client.oidcVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tr.store.Put(sessionID, &StoredToken{OAuth2Token: newToken, RawIDToken: rawIDToken}); err != nil {
+		return nil, nil, err
+	}
+
+	return newToken, idToken, nil
+}
+
+// Start launches a background goroutine that polls the store every pollInterval and proactively
+// refreshes any session whose access token expires within margin. It stops when ctx is done, or
+// when the returned stop function is called.
+func (tr *TokenRefresher) Start(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		ticker := time.NewTicker(tr.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tr.refreshExpiring(ctx)
+			}
+		}
+	}()
+
+	return cancel
+}
+
+func (tr *TokenRefresher) refreshExpiring(ctx context.Context) {
+	for _, sessionID := range tr.store.Sessions() {
+		stored, err := tr.store.Get(sessionID)
+		if err != nil {
+			continue
+		}
+		if time.Until(stored.OAuth2Token.Expiry) > tr.margin {
+			continue
+		}
+
+		if _, _, err := tr.Refresh(ctx, sessionID); err != nil {
+			tr.client.logger.Warn().Err(err).Str("sid", sessionID).Msg("proactive token refresh failed, dropping session")
+			if delErr := tr.store.Delete(sessionID); delErr != nil {
+				tr.client.logger.Error().Err(delErr).Str("sid", sessionID).Msg("failed to drop session after failed refresh")
+			}
+		}
+	}
+}
+
+// setSessionCookie writes the session id cookie used to look up a stored, refreshable session.
+// Unlike the login state, this cookie holds only an opaque, unguessable reference into the
+// TokenStore, so it is set directly rather than through a sessions.Store.
+func setSessionCookie(w http.ResponseWriter, sid string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    sid,
+		Path:     DefaultCookiePath,
+		Secure:   true,
+		HttpOnly: true,
+	})
+}
+
+// clearSessionCookie deletes the session id cookie from the browser.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     DefaultCookiePath,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		Secure:   true,
+		HttpOnly: true,
+	})
+}
@@ -0,0 +1,356 @@
+package psql
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldKind identifies how a registered field's query parameter values are parsed.
+type FieldKind int
+
+const (
+	FieldString FieldKind = iota
+	FieldTime
+	FieldBool
+	FieldEnum
+)
+
+// FieldSpec describes one field a QueryParser accepts in the query string.
+type FieldSpec struct {
+	Kind FieldKind
+
+	// Column is the WhereBuilder query fragment to filter on, e.g.
+	// `blob->>'metadata_provider_org'=$` for a FieldString, or a bare column/expression for
+	// FieldTime and FieldBool.
+	Column string
+
+	// SortColumn is the bare column or expression to use when this field appears in "sort".
+	// It's separate from Column because Column is often a WHERE fragment (a "=$" comparison,
+	// or for FieldBool a whole fixed predicate like "published=false") that isn't valid SQL
+	// after ORDER BY. Empty means the field can't be sorted on.
+	SortColumn string
+
+	// Enum lists the valid values for a FieldEnum; ignored otherwise.
+	Enum []string
+}
+
+// Typed errors so handlers can turn a Parse failure into a JSON problem-detail body without
+// string matching.
+var (
+	// ErrUnknownField is returned for a query parameter that was never registered with RegisterField.
+	ErrUnknownField = errors.New("unknown field")
+
+	// ErrInvalidTime is returned when a time-kind field's value can't be parsed by ParseTimeFilter.
+	ErrInvalidTime = errors.New("invalid time value")
+
+	// ErrInvalidValue is returned when a bool, enum or pagination value isn't acceptable.
+	ErrInvalidValue = errors.New("invalid value")
+
+	// ErrInvalidCursor is returned when a "cursor" parameter can't be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrNotSortable is returned for a "sort" term naming a field with no SortColumn.
+	ErrNotSortable = errors.New("field cannot be sorted on")
+
+	// ErrIncompatibleSort is returned when "cursor" is combined with a "sort" the keyset
+	// predicate can't express: cursor pagination walks the (created, id) tuple, so the only
+	// sort it supports is a lone "created" term, ascending or descending.
+	ErrIncompatibleSort = errors.New("cursor pagination only supports sorting by created")
+)
+
+// DefaultLimit and MaxLimit bound the page size accepted from the "limit" query parameter.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Cursor is an opaque (created, id) tuple used for keyset pagination: rows are ordered by
+// created, id and a page starts strictly after the cursor's position.
+type Cursor struct {
+	Created time.Time `json:"created"`
+	ID      string    `json:"id"`
+}
+
+// Encode returns the opaque string representation of the cursor, suitable for a "cursor" query parameter.
+func (c Cursor) Encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor parses a cursor previously produced by Cursor.Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// SortField is one term of an ORDER BY clause.
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// Query is the result of parsing url.Values against a QueryParser's registered fields. Where
+// is the sole SQL filtering output; GroupBy is kept separately because DatasetFilterGroupByPaths
+// maps it to a SELECT/GROUP BY expression rather than a WHERE condition. Limit, Offset and
+// Cursor are for the caller's pagination logic; Sort is rendered to SQL by OrderBy.
+type Query struct {
+	GroupBy string
+	Where   *WhereBuilder
+	Limit   int
+	Offset  int
+	Cursor  *Cursor
+	Sort    []SortField
+}
+
+// OrderBy renders q.Sort as a SQL ORDER BY clause, or "" if there's nothing to sort by. When a
+// Cursor is set and no explicit sort was requested, rows are ordered by (created, id), the
+// tuple a cursor compares against, so keyset pagination stays stable.
+func (q *Query) OrderBy() string {
+	fields := q.Sort
+	if len(fields) == 0 && q.Cursor != nil {
+		fields = []SortField{{Column: "created"}, {Column: "id"}}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(fields))
+	for i, f := range fields {
+		if f.Desc {
+			terms[i] = f.Column + " DESC"
+		} else {
+			terms[i] = f.Column
+		}
+	}
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
+
+// QueryParser turns url.Values from an HTTP request into a Query, against a schema of fields
+// registered ahead of time with RegisterField. It understands repeated and suffixed parameters
+// for comparisons (e.g. "created=2019-08&created_lt=2019-09"), "group_by", "limit", "offset",
+// "cursor" and "sort", on top of the registered fields.
+type QueryParser struct {
+	fields map[string]FieldSpec
+}
+
+// NewQueryParser creates an empty QueryParser. Fields must be registered with RegisterField
+// before Parse will accept query parameters for them.
+func NewQueryParser() *QueryParser {
+	return &QueryParser{fields: make(map[string]FieldSpec)}
+}
+
+// NewDatasetQueryParser creates a QueryParser pre-registered with the fields of DatasetFilter.
+func NewDatasetQueryParser() *QueryParser {
+	p := NewQueryParser()
+	p.RegisterField("created", FieldTime, "created")
+	p.RegisterSortableField("user", FieldString, `blob->>'metadata_provider_user'=$`, `blob->>'metadata_provider_user'`)
+	p.RegisterSortableField("organization", FieldString, `blob->>'metadata_provider_org'=$`, `blob->>'metadata_provider_org'`)
+	p.RegisterField("only_drafts", FieldBool, `published=false`)
+	p.RegisterField("only_published", FieldBool, `published=true`)
+	p.RegisterField("only_att", FieldBool, `schema='metax-att'`)
+	p.RegisterField("only_ida", FieldBool, `schema='metax-ida'`)
+	return p
+}
+
+// RegisterField adds a field named name of the given kind, filtering on column. For FieldEnum,
+// enum lists the valid values; it is ignored for other kinds. FieldTime's column doubles as its
+// SortColumn, since it's already a bare column; other kinds aren't sortable unless registered
+// with RegisterSortableField instead.
+func (p *QueryParser) RegisterField(name string, kind FieldKind, column string, enum ...string) {
+	spec := FieldSpec{Kind: kind, Column: column, Enum: enum}
+	if kind == FieldTime {
+		spec.SortColumn = column
+	}
+	p.fields[name] = spec
+}
+
+// RegisterSortableField is like RegisterField, but also registers sortColumn, the bare column
+// or expression used when this field appears in "sort". Use it whenever column isn't itself a
+// valid ORDER BY expression, e.g. FieldString's "col=$" comparison fragment.
+func (p *QueryParser) RegisterSortableField(name string, kind FieldKind, column, sortColumn string, enum ...string) {
+	p.fields[name] = FieldSpec{Kind: kind, Column: column, SortColumn: sortColumn, Enum: enum}
+}
+
+// Parse ingests url.Values and produces a Query, or the first error encountered.
+func (p *QueryParser) Parse(values url.Values) (*Query, error) {
+	var groupBy string
+	wb := NewWhereBuilder()
+
+	for name, vals := range values {
+		switch name {
+		case "group_by":
+			val := vals[0]
+			if _, ok := DatasetFilterGroupByPaths[val]; !ok {
+				return nil, fmt.Errorf("%w: group_by=%q", ErrInvalidValue, val)
+			}
+			groupBy = val
+			continue
+		case "limit", "offset", "cursor", "sort":
+			continue // handled below, once each, rather than per value
+		}
+
+		field, suffix := splitComparisonSuffix(name)
+		spec, ok := p.fields[field]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+		}
+
+		for _, val := range vals {
+			if err := p.applyField(wb, spec, suffix, val); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	limit, err := parseBoundedInt(values.Get("limit"), DefaultLimit, MaxLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := parseBoundedInt(values.Get("offset"), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	sortFields, err := p.parseSort(values.Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor *Cursor
+	if s := values.Get("cursor"); s != "" {
+		c, err := DecodeCursor(s)
+		if err != nil {
+			return nil, err
+		}
+		cursor = &c
+
+		desc := false
+		switch {
+		case len(sortFields) == 0:
+			// falls back to the same ascending (created, id) order OrderBy defaults to.
+		case len(sortFields) == 1 && sortFields[0].Column == "created":
+			desc = sortFields[0].Desc
+		default:
+			return nil, ErrIncompatibleSort
+		}
+
+		// keyset pagination: only rows strictly after (or, descending, before) the cursor's
+		// (created, id) position.
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		wb.cprintf("(created, id) "+op+" (%s, %s)", wb.addArg(cursor.Created), wb.addArg(cursor.ID))
+	}
+
+	return &Query{GroupBy: groupBy, Where: wb, Limit: limit, Offset: offset, Cursor: cursor, Sort: sortFields}, nil
+}
+
+func (p *QueryParser) applyField(wb *WhereBuilder, spec FieldSpec, suffix, val string) error {
+	switch spec.Kind {
+	case FieldTime:
+		filter := ParseTimeFilter(suffix, val)
+		if filter.IsZero() {
+			return fmt.Errorf("%w: %q", ErrInvalidTime, val)
+		}
+		wb.MaybeAddTimeFilter(filter, spec.Column)
+
+	case FieldBool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidValue, val)
+		}
+		wb.MaybeAdd(b, spec.Column)
+
+	case FieldEnum:
+		if !contains(spec.Enum, val) {
+			return fmt.Errorf("%w: %q", ErrInvalidValue, val)
+		}
+		wb.MaybeAddString(val, spec.Column)
+
+	default: // FieldString
+		wb.MaybeAddString(val, spec.Column)
+	}
+	return nil
+}
+
+func (p *QueryParser) parseSort(s string) ([]SortField, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	terms := strings.Split(s, ",")
+	fields := make([]SortField, 0, len(terms))
+
+	for _, term := range terms {
+		desc := strings.HasPrefix(term, "-")
+		name := strings.TrimPrefix(term, "-")
+
+		spec, ok := p.fields[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: sort=%s", ErrUnknownField, name)
+		}
+		if spec.SortColumn == "" {
+			return nil, fmt.Errorf("%w: sort=%s", ErrNotSortable, name)
+		}
+		fields = append(fields, SortField{Column: spec.SortColumn, Desc: desc})
+	}
+
+	return fields, nil
+}
+
+// parseBoundedInt parses s as a non-negative int, returning def if s is empty. If max is
+// positive, the result is capped at max rather than rejected, the common way APIs treat an
+// oversized page size request.
+func parseBoundedInt(s string, def, max int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidValue, s)
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	return n, nil
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// splitComparisonSuffix splits name into its field name and comparison suffix, e.g.
+// "created_lt" -> ("created", "_lt"), so it can be looked up both in fields and ComparisonSuffixes.
+func splitComparisonSuffix(name string) (field, suffix string) {
+	for s := range ComparisonSuffixes {
+		if s != "" && strings.HasSuffix(name, s) {
+			return strings.TrimSuffix(name, s), s
+		}
+	}
+	return name, ""
+}
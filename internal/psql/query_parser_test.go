@@ -0,0 +1,211 @@
+package psql
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestQueryParserFields(t *testing.T) {
+	p := NewQueryParser()
+	p.RegisterSortableField("user", FieldString, `blob->>'metadata_provider_user'=$`, `blob->>'metadata_provider_user'`)
+	p.RegisterField("created", FieldTime, "created")
+	p.RegisterField("only_drafts", FieldBool, `published=false`)
+	p.RegisterField("access_type", FieldEnum, "access_type=$", "open", "restricted")
+
+	t.Run("string", func(t *testing.T) {
+		q, err := p.Parse(url.Values{"user": {"testimatti"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		where, args := q.Where.Where()
+		if where != `WHERE blob->>'metadata_provider_user'=$1` {
+			t.Errorf("unexpected where clause: %q", where)
+		}
+		if len(args) != 1 || args[0] != "testimatti" {
+			t.Errorf("unexpected args: %v", args)
+		}
+	})
+
+	t.Run("repeated time comparisons", func(t *testing.T) {
+		q, err := p.Parse(url.Values{
+			"created":    {"2019-08"},
+			"created_lt": {"2019-09"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, args := q.Where.Where()
+		if len(args) != 3 {
+			t.Errorf("expected 3 args (equality range + lt), got %d: %v", len(args), args)
+		}
+	})
+
+	t.Run("bool", func(t *testing.T) {
+		q, err := p.Parse(url.Values{"only_drafts": {"true"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		where, _ := q.Where.Where()
+		if where != "WHERE published=false" {
+			t.Errorf("unexpected where clause: %q", where)
+		}
+
+		if _, err := p.Parse(url.Values{"only_drafts": {"nope"}}); err == nil {
+			t.Error("expected an error for an invalid bool value")
+		}
+	})
+
+	t.Run("enum", func(t *testing.T) {
+		if _, err := p.Parse(url.Values{"access_type": {"bogus"}}); err == nil {
+			t.Error("expected an error for an invalid enum value")
+		}
+		q, err := p.Parse(url.Values{"access_type": {"open"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		where, _ := q.Where.Where()
+		if where != "WHERE access_type=$1" {
+			t.Errorf("unexpected where clause: %q", where)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := p.Parse(url.Values{"bogus": {"x"}})
+		if !errors.Is(err, ErrUnknownField) {
+			t.Errorf("expected ErrUnknownField, got %v", err)
+		}
+	})
+}
+
+func TestQueryParserGroupBy(t *testing.T) {
+	p := NewQueryParser()
+
+	q, err := p.Parse(url.Values{"group_by": {"organization"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.GroupBy != "organization" {
+		t.Errorf("expected GroupBy %q, got %q", "organization", q.GroupBy)
+	}
+
+	if _, err := p.Parse(url.Values{"group_by": {"bogus"}}); err == nil {
+		t.Error("expected an error for an unknown group_by value")
+	}
+}
+
+func TestQueryParserPagination(t *testing.T) {
+	p := NewQueryParser()
+	p.RegisterField("created", FieldTime, "created")
+
+	t.Run("defaults", func(t *testing.T) {
+		q, err := p.Parse(url.Values{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Limit != DefaultLimit || q.Offset != 0 {
+			t.Errorf("expected defaults %d/0, got %d/%d", DefaultLimit, q.Limit, q.Offset)
+		}
+	})
+
+	t.Run("limit is capped", func(t *testing.T) {
+		q, err := p.Parse(url.Values{"limit": {"100000"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Limit != MaxLimit {
+			t.Errorf("expected limit capped to %d, got %d", MaxLimit, q.Limit)
+		}
+	})
+
+	t.Run("negative offset rejected", func(t *testing.T) {
+		if _, err := p.Parse(url.Values{"offset": {"-1"}}); err == nil {
+			t.Error("expected an error for a negative offset")
+		}
+	})
+
+	t.Run("cursor round-trips and adds a keyset condition", func(t *testing.T) {
+		cursor := Cursor{Created: time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC), ID: "abc"}
+		encoded, err := cursor.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q, err := p.Parse(url.Values{"cursor": {encoded}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Cursor == nil || q.Cursor.ID != "abc" {
+			t.Errorf("expected decoded cursor with ID %q, got %v", "abc", q.Cursor)
+		}
+		where, args := q.Where.Where()
+		if where != "WHERE (created, id) > ($1, $2)" {
+			t.Errorf("unexpected where clause: %q", where)
+		}
+		if len(args) != 2 {
+			t.Errorf("expected 2 args for the keyset condition, got %d", len(args))
+		}
+		if order := q.OrderBy(); order != "ORDER BY created, id" {
+			t.Errorf("expected cursor to default the sort order, got %q", order)
+		}
+	})
+
+	t.Run("invalid cursor rejected", func(t *testing.T) {
+		if _, err := p.Parse(url.Values{"cursor": {"not valid base64url json"}}); err != ErrInvalidCursor {
+			t.Errorf("expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
+	t.Run("descending sort flips the keyset comparison", func(t *testing.T) {
+		cursor := Cursor{Created: time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC), ID: "abc"}
+		encoded, err := cursor.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q, err := p.Parse(url.Values{"cursor": {encoded}, "sort": {"-created"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		where, _ := q.Where.Where()
+		if where != "WHERE (created, id) < ($1, $2)" {
+			t.Errorf("unexpected where clause: %q", where)
+		}
+	})
+
+	t.Run("cursor with an incompatible sort is rejected", func(t *testing.T) {
+		encoded, err := (Cursor{Created: time.Now(), ID: "abc"}).Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Parse(url.Values{"cursor": {encoded}, "sort": {"created,created"}}); !errors.Is(err, ErrIncompatibleSort) {
+			t.Errorf("expected ErrIncompatibleSort, got %v", err)
+		}
+	})
+}
+
+func TestQueryParserSort(t *testing.T) {
+	p := NewQueryParser()
+	p.RegisterField("created", FieldTime, "created")
+	p.RegisterSortableField("user", FieldString, `blob->>'metadata_provider_user'=$`, "metadata_provider_user")
+	p.RegisterField("only_drafts", FieldBool, "published=false")
+
+	q, err := p.Parse(url.Values{"sort": {"-created,user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order := q.OrderBy(); order != "ORDER BY created DESC, metadata_provider_user" {
+		t.Errorf("unexpected order by clause: %q", order)
+	}
+
+	if _, err := p.Parse(url.Values{"sort": {"bogus"}}); !errors.Is(err, ErrUnknownField) {
+		t.Errorf("expected ErrUnknownField, got %v", err)
+	}
+
+	t.Run("a field with no SortColumn is rejected", func(t *testing.T) {
+		if _, err := p.Parse(url.Values{"sort": {"only_drafts"}}); !errors.Is(err, ErrNotSortable) {
+			t.Errorf("expected ErrNotSortable, got %v", err)
+		}
+	})
+}
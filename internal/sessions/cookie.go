@@ -0,0 +1,120 @@
+// Package sessions provides encrypted, size-limited cookie storage with pluggable backends.
+//
+// A Store hides three concerns any handler that sets a cookie with non-trivial content would
+// otherwise have to deal with itself: encrypting and integrity-protecting the payload with
+// fernet-go, splitting it across several cookies if it doesn't fit the common ~4KB ceiling, and
+// choosing where the payload actually lives (in the cookie itself, or server-side in Redis with
+// only a session id in the cookie).
+package sessions
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxCookieSize is the largest single cookie value segment a Store writes, safely under the
+// common 4KB-per-cookie browser ceiling once the cookie's name, flags and overhead are counted.
+const maxCookieSize = 3900
+
+// maxCookieSegments bounds both how many segments a Store will write for one payload and how
+// many Clear removes; it exists only to put a finite bound on Clear's cleanup loop.
+const maxCookieSegments = 16
+
+// ErrCookieNotFound is returned by Load when no cookie is set for the given name.
+var ErrCookieNotFound = errors.New("session cookie not found")
+
+// ErrPayloadTooLarge is returned by Save when a payload needs more than maxCookieSegments
+// cookies to store, since joinCookies and clearSplitCookies only ever look at that many.
+var ErrPayloadTooLarge = errors.New("session payload too large to split across cookies")
+
+// CookieOptions controls the flags and path used for the cookies a Store sets.
+type CookieOptions struct {
+	Path     string
+	Secure   bool
+	HttpOnly bool
+
+	// MaxAge is the cookie lifetime; zero makes it a "session cookie" that expires when the
+	// browser closes, rather than at a fixed time.
+	MaxAge time.Duration
+}
+
+// setSplitCookies base64-encodes data and writes it across as many name_0, name_1, ... cookies
+// as needed to stay under maxCookieSize per segment, up to maxCookieSegments. It returns
+// ErrPayloadTooLarge rather than silently writing a segment joinCookies and clearSplitCookies
+// will never look at.
+func setSplitCookies(w http.ResponseWriter, name string, data []byte, opts CookieOptions) error {
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+
+	for i := 0; i < maxCookieSegments; i++ {
+		end := maxCookieSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+
+		cookie := &http.Cookie{
+			Name:     segmentName(name, i),
+			Value:    encoded[:end],
+			Path:     opts.Path,
+			Secure:   opts.Secure,
+			HttpOnly: opts.HttpOnly,
+		}
+		if opts.MaxAge > 0 {
+			cookie.Expires = time.Now().Add(opts.MaxAge)
+			cookie.MaxAge = int(opts.MaxAge / time.Second)
+		}
+		http.SetCookie(w, cookie)
+
+		encoded = encoded[end:]
+		if len(encoded) == 0 {
+			return nil
+		}
+	}
+
+	return ErrPayloadTooLarge
+}
+
+// joinCookies reassembles and base64-decodes the segments previously written by setSplitCookies.
+func joinCookies(r *http.Request, name string) ([]byte, error) {
+	first, err := r.Cookie(segmentName(name, 0))
+	if err != nil {
+		return nil, ErrCookieNotFound
+	}
+
+	var b strings.Builder
+	b.WriteString(first.Value)
+
+	for i := 1; i < maxCookieSegments; i++ {
+		cookie, err := r.Cookie(segmentName(name, i))
+		if err != nil {
+			break
+		}
+		b.WriteString(cookie.Value)
+	}
+
+	return base64.RawURLEncoding.DecodeString(b.String())
+}
+
+// clearSplitCookies deletes up to maxCookieSegments segment cookies for name. It always clears
+// the full range rather than stopping at the first miss, since the caller may not know how many
+// segments were originally written (e.g. after a code change that shrunk the payload).
+func clearSplitCookies(w http.ResponseWriter, name string, opts CookieOptions) {
+	for i := 0; i < maxCookieSegments; i++ {
+		http.SetCookie(w, &http.Cookie{
+			Name:     segmentName(name, i),
+			Value:    "",
+			Path:     opts.Path,
+			MaxAge:   -1,
+			Expires:  time.Unix(0, 0),
+			Secure:   opts.Secure,
+			HttpOnly: opts.HttpOnly,
+		})
+	}
+}
+
+func segmentName(name string, i int) string {
+	return fmt.Sprintf("%s_%d", name, i)
+}
@@ -0,0 +1,43 @@
+package sessions
+
+import (
+	"net/http"
+	"time"
+)
+
+// CookieStore is a Store that keeps the encrypted, possibly split, payload entirely in
+// cookies; it requires no server-side storage.
+type CookieStore struct {
+	cipher *cipher
+	opts   CookieOptions
+}
+
+// NewCookieStore creates a CookieStore that encrypts payloads with secret, a 32 byte key, and
+// rejects anything saved more than maxAge ago.
+func NewCookieStore(secret []byte, maxAge time.Duration, opts CookieOptions) (*CookieStore, error) {
+	c, err := newCipher(secret, maxAge)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieStore{cipher: c, opts: opts}, nil
+}
+
+func (s *CookieStore) Save(w http.ResponseWriter, name string, value []byte) error {
+	token, err := s.cipher.encrypt(value)
+	if err != nil {
+		return err
+	}
+	return setSplitCookies(w, name, token, s.opts)
+}
+
+func (s *CookieStore) Load(r *http.Request, name string) ([]byte, error) {
+	token, err := joinCookies(r, name)
+	if err != nil {
+		return nil, err
+	}
+	return s.cipher.decrypt(token)
+}
+
+func (s *CookieStore) Clear(w http.ResponseWriter, name string) {
+	clearSplitCookies(w, name, s.opts)
+}
@@ -0,0 +1,82 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func recordedCookies(t *testing.T, write func(w http.ResponseWriter)) *http.Request {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	write(rec)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestSplitCookiesRoundTrip(t *testing.T) {
+	opts := CookieOptions{Path: "/"}
+	data := []byte(strings.Repeat("x", maxCookieSize*2+100)) // spans 3 segments
+
+	var setErr error
+	req := recordedCookies(t, func(w http.ResponseWriter) {
+		setErr = setSplitCookies(w, "qvain_test", data, opts)
+	})
+	if setErr != nil {
+		t.Fatalf("setSplitCookies: %v", setErr)
+	}
+
+	joined, err := joinCookies(req, "qvain_test")
+	if err != nil {
+		t.Fatalf("joinCookies: %v", err)
+	}
+	if string(joined) != string(data) {
+		t.Errorf("joined data didn't round-trip: got %d bytes, want %d", len(joined), len(data))
+	}
+}
+
+func TestSplitCookiesTooLarge(t *testing.T) {
+	opts := CookieOptions{Path: "/"}
+	data := make([]byte, maxCookieSize*maxCookieSegments*2)
+
+	req := recordedCookies(t, func(w http.ResponseWriter) {
+		if err := setSplitCookies(w, "qvain_test", data, opts); err != ErrPayloadTooLarge {
+			t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+		}
+	})
+
+	// whatever got written before hitting the segment bound should still be in range, even
+	// though Save should have reported the overflow to its caller.
+	if _, err := joinCookies(req, "qvain_test"); err != nil && err != ErrCookieNotFound {
+		t.Errorf("unexpected error reassembling a too-large payload's segments: %v", err)
+	}
+}
+
+func TestJoinCookiesMissing(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := joinCookies(req, "qvain_test"); err != ErrCookieNotFound {
+		t.Errorf("expected ErrCookieNotFound, got %v", err)
+	}
+}
+
+func TestClearSplitCookiesBounded(t *testing.T) {
+	var cleared []*http.Cookie
+	rec := httptest.NewRecorder()
+	clearSplitCookies(rec, "qvain_test", CookieOptions{Path: "/"})
+	cleared = rec.Result().Cookies()
+
+	if len(cleared) != maxCookieSegments {
+		t.Errorf("expected %d cleared segments, got %d", maxCookieSegments, len(cleared))
+	}
+	for _, c := range cleared {
+		if c.MaxAge >= 0 {
+			t.Errorf("expected a negative MaxAge to delete %s, got %d", c.Name, c.MaxAge)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sessions
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fernet/fernet-go"
+)
+
+const secretKeyLength = 32
+
+// ErrInvalidSecret is returned when the configured encryption secret is not exactly 32 bytes.
+var ErrInvalidSecret = errors.New("session encryption secret must be 32 bytes")
+
+// ErrDecryptFailed is returned when a cookie payload fails to decrypt or verify, e.g. because
+// it was forged, truncated, or has expired.
+var ErrDecryptFailed = errors.New("session cookie decryption failed")
+
+// cipher encrypts and authenticates Store payloads with fernet, rejecting anything older than maxAge.
+type cipher struct {
+	key    *fernet.Key
+	maxAge time.Duration
+}
+
+func newCipher(secret []byte, maxAge time.Duration) (*cipher, error) {
+	if len(secret) != secretKeyLength {
+		return nil, ErrInvalidSecret
+	}
+
+	var key fernet.Key
+	copy(key[:], secret)
+
+	return &cipher{key: &key, maxAge: maxAge}, nil
+}
+
+func (c *cipher) encrypt(plaintext []byte) ([]byte, error) {
+	return fernet.EncryptAndSign(plaintext, c.key)
+}
+
+func (c *cipher) decrypt(token []byte) ([]byte, error) {
+	plaintext := fernet.VerifyAndDecrypt(token, c.maxAge, []*fernet.Key{c.key})
+	if plaintext == nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
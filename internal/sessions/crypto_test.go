@@ -0,0 +1,70 @@
+package sessions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCipherRoundTrip(t *testing.T) {
+	secret := []byte(strings.Repeat("k", secretKeyLength))
+	c, err := newCipher(secret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := c.encrypt([]byte("hello session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := c.decrypt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "hello session" {
+		t.Errorf("got %q, want %q", plaintext, "hello session")
+	}
+}
+
+func TestCipherRejectsWrongKey(t *testing.T) {
+	c1, err := newCipher([]byte(strings.Repeat("k", secretKeyLength)), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := newCipher([]byte(strings.Repeat("j", secretKeyLength)), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := c1.encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.decrypt(token); err != ErrDecryptFailed {
+		t.Errorf("expected ErrDecryptFailed decrypting with the wrong key, got %v", err)
+	}
+}
+
+func TestCipherRejectsExpired(t *testing.T) {
+	c, err := newCipher([]byte(strings.Repeat("k", secretKeyLength)), time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := c.encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := c.decrypt(token); err != ErrDecryptFailed {
+		t.Errorf("expected ErrDecryptFailed for an expired token, got %v", err)
+	}
+}
+
+func TestNewCipherRejectsBadSecretLength(t *testing.T) {
+	if _, err := newCipher([]byte("too short"), time.Minute); err != ErrInvalidSecret {
+		t.Errorf("expected ErrInvalidSecret, got %v", err)
+	}
+}
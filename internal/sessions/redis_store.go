@@ -0,0 +1,73 @@
+package sessions
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/CSCfi/qvain-api/internal/randomkey"
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisStore is a Store that keeps the payload in Redis under a random session id, setting
+// only that (encrypted) id in the cookie. This sidesteps the cookie size ceiling entirely and
+// lets sessions be revoked or inspected server-side.
+type RedisStore struct {
+	pool   *redis.Pool
+	cipher *cipher
+	opts   CookieOptions
+	expire time.Duration
+	prefix string
+}
+
+// NewRedisStore creates a RedisStore using pool for storage, encrypting the session id in the
+// cookie with secret, a 32 byte key. Stored payloads expire from Redis after expire.
+func NewRedisStore(pool *redis.Pool, secret []byte, expire time.Duration, opts CookieOptions) (*RedisStore, error) {
+	// the cookie only ever holds a session id, which doesn't carry its own timestamp to check,
+	// so the cipher's maxAge check is disabled; Redis' own TTL is what expires the session.
+	c, err := newCipher(secret, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisStore{pool: pool, cipher: c, opts: opts, expire: expire, prefix: "qvain:session:"}, nil
+}
+
+func (s *RedisStore) Save(w http.ResponseWriter, name string, value []byte) error {
+	key, err := randomkey.Random16()
+	if err != nil {
+		return err
+	}
+	sid := key.Base64()
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("SETEX", s.prefix+sid, int(s.expire/time.Second), value); err != nil {
+		return err
+	}
+
+	token, err := s.cipher.encrypt([]byte(sid))
+	if err != nil {
+		return err
+	}
+	return setSplitCookies(w, name, token, s.opts)
+}
+
+func (s *RedisStore) Load(r *http.Request, name string) ([]byte, error) {
+	token, err := joinCookies(r, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := s.cipher.decrypt(token)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+	return redis.Bytes(conn.Do("GET", s.prefix+string(sid)))
+}
+
+func (s *RedisStore) Clear(w http.ResponseWriter, name string) {
+	clearSplitCookies(w, name, s.opts)
+}
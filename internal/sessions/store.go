@@ -0,0 +1,18 @@
+package sessions
+
+import "net/http"
+
+// Store persists an arbitrary payload under a cookie name, transparently encrypting it and
+// splitting it across several cookies if it doesn't fit in one. Handlers that used to call
+// http.SetCookie/r.Cookie directly should go through a Store instead.
+type Store interface {
+	// Save encrypts value and writes it to the response under name.
+	Save(w http.ResponseWriter, name string, value []byte) error
+
+	// Load reads back and decrypts the value previously saved under name. It returns
+	// ErrCookieNotFound if no cookie is set, and ErrDecryptFailed if it doesn't verify.
+	Load(r *http.Request, name string) ([]byte, error)
+
+	// Clear removes any cookies previously set for name.
+	Clear(w http.ResponseWriter, name string)
+}